@@ -0,0 +1,292 @@
+package sftps
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"time"
+)
+
+// watchContext arranges for the given closers - any mix of net.Conn,
+// tls.Conn and net.Listener all satisfy io.Closer - to be closed as soon
+// as ctx is cancelled, unblocking any in-flight Read/Write/Accept on them
+// the same way net.Dialer.DialContext unblocks a pending dial. The
+// returned stop func must be called once the operation being guarded has
+// finished, to release the watcher goroutine.
+//
+// Closing (rather than just deadlining) the connection on cancellation is
+// deliberate: a past SetDeadline left in place after a cancelled call
+// would silently fail every later operation on a reused *Ftp, even under
+// a fresh, uncancelled context. Closing instead makes the failure mode
+// obvious and final. Callers of the *Context methods below must treat
+// this *Ftp as unusable once ctx has been cancelled, and reconnect rather
+// than reuse it.
+func (this *Ftp) watchContext(ctx context.Context, closers ...io.Closer) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			for _, c := range closers {
+				if c != nil {
+					c.Close()
+				}
+			}
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// ctrlConnNetConn returns the net.Conn currently backing the control
+// connection, whichever of rawConn/tlsConn is in use. This must be judged
+// by which field is actually populated, not by params.secure: in EXPLICIT
+// mode the connection starts out on plain rawConn and only becomes
+// tlsConn once secureUpgrade runs, so params.secure alone would return a
+// nil *tls.Conn boxed in a non-nil net.Conn before the AUTH TLS upgrade.
+func (this *Ftp) ctrlConnNetConn() net.Conn {
+	if this.tlsConn != nil {
+		return this.tlsConn
+	}
+	return this.rawConn
+}
+
+// ConnectContext is the context-aware variant of connect. The dial itself
+// is cancelled via net.Dialer.DialContext, and the initial greeting read
+// is cancelled via watchContext.
+func (this *Ftp) ConnectContext(ctx context.Context) (res *FtpResponse, err error) {
+	var ipaddr []net.IP
+	var code int
+	var msg string
+
+	if ipaddr, err = net.LookupIP(this.params.host); err != nil {
+		return
+	}
+
+	addr := fmt.Sprintf("%s:%d", ipaddr[0], this.params.port)
+
+	dialer := new(net.Dialer)
+	if dialer.Timeout, err = time.ParseDuration(TIMEOUT); err != nil {
+		return
+	}
+	if dialer.KeepAlive, err = time.ParseDuration(KEEPALIVE); err != nil {
+		return
+	}
+
+	if this.params.secure && this.params.secureMode == IMPLICIT {
+		var conf *tls.Config
+		if conf, err = this.getTLSConfig(); err != nil {
+			return
+		}
+		var rawConn net.Conn
+		if rawConn, err = dialer.DialContext(ctx, "tcp", addr); err != nil {
+			return
+		}
+		this.tlsConn = tls.Client(rawConn, conf)
+		if err = this.tlsConn.HandshakeContext(ctx); err != nil {
+			return
+		}
+		this.ctrlConn = textproto.NewConn(this.tlsConn)
+	} else {
+		if this.rawConn, err = dialer.DialContext(ctx, "tcp", addr); err != nil {
+			return
+		}
+		this.ctrlConn = textproto.NewConn(this.rawConn)
+	}
+
+	stop := this.watchContext(ctx, this.ctrlConnNetConn())
+	defer stop()
+
+	if code, msg, err = this.ctrlConn.ReadResponse(220); err != nil {
+		return
+	}
+
+	res = &FtpResponse{
+		command: "",
+		code:    code,
+		msg:     msg,
+	}
+
+	this.State = ONLINE
+	return
+}
+
+// CommandContext is the context-aware variant of Command: ctx cancellation
+// closes the control connection, unblocking the Cmd/ReadResponse pair
+// below. This Ftp must be discarded if ctx is cancelled; see watchContext.
+func (this *Ftp) CommandContext(ctx context.Context, cmd string, code int) (res *FtpResponse, err error) {
+	stop := this.watchContext(ctx, this.ctrlConnNetConn())
+	defer stop()
+	return this.Command(cmd, code)
+}
+
+// ListContext is the context-aware variant of list.
+func (this *Ftp) ListContext(ctx context.Context, p string) (res []*FtpResponse, list string, err error) {
+	stop := this.watchContext(ctx, this.ctrlConnNetConn())
+	defer stop()
+
+	res = []*FtpResponse{}
+	if !this.params.keepAlive {
+		defer func() {
+			var r *FtpResponse
+			if r, err = this.quit(); err != nil {
+				return
+			}
+			res = append(res, r)
+			return
+		}()
+	}
+
+	var itf interface{}
+	var bytes []byte
+	var r *FtpResponse
+
+	cmd := fmt.Sprintf("LIST -aL %s", p)
+
+	if this.params.passive {
+		if r, itf, err = this.openPassiveConn(); err != nil {
+			return
+		}
+	} else {
+		if r, itf, err = this.openActiveConn(); err != nil {
+			return
+		}
+	}
+	res = append(res, r)
+
+	if dc, ok := itf.(net.Conn); ok {
+		dataStop := this.watchContext(ctx, dc)
+		defer dataStop()
+	} else if l, ok := itf.(net.Listener); ok {
+		// Active mode: itf is still just the listener at this point, so
+		// closing it on cancellation at least unblocks the Accept() that
+		// readBytes/fileTransfer is about to perform.
+		dataStop := this.watchContext(ctx, l)
+		defer dataStop()
+	}
+
+	if r, err = this.CommandContext(ctx, cmd, 150); err != nil {
+		return
+	}
+	res = append(res, r)
+
+	if r, bytes, err = this.readBytes(itf); err != nil {
+		return
+	}
+	res = append(res, r)
+
+	list = string(bytes)
+	return
+}
+
+// DownloadContext is the context-aware variant of download.
+func (this *Ftp) DownloadContext(ctx context.Context, local string, remote string) (res []*FtpResponse, len int64, err error) {
+	stop := this.watchContext(ctx, this.ctrlConnNetConn())
+	defer stop()
+
+	res = []*FtpResponse{}
+	if !this.params.keepAlive {
+		defer func() {
+			var r *FtpResponse
+			if r, err = this.quit(); err != nil {
+				return
+			}
+			res = append(res, r)
+			return
+		}()
+	}
+
+	var itf interface{}
+	var r *FtpResponse
+
+	if this.params.passive {
+		if r, itf, err = this.openPassiveConn(); err != nil {
+			return
+		}
+	} else {
+		if r, itf, err = this.openActiveConn(); err != nil {
+			return
+		}
+	}
+	res = append(res, r)
+
+	if dc, ok := itf.(net.Conn); ok {
+		dataStop := this.watchContext(ctx, dc)
+		defer dataStop()
+	} else if l, ok := itf.(net.Listener); ok {
+		// Active mode: itf is still just the listener at this point, so
+		// closing it on cancellation at least unblocks the Accept() that
+		// readBytes/fileTransfer is about to perform.
+		dataStop := this.watchContext(ctx, l)
+		defer dataStop()
+	}
+
+	var cmd = fmt.Sprintf("RETR %s", remote)
+	if r, err = this.CommandContext(ctx, cmd, 150); err != nil {
+		return
+	}
+	res = append(res, r)
+
+	if r, len, err = this.fileTransfer(DOWNLOAD, local, itf); err != nil {
+		return
+	}
+	res = append(res, r)
+	return
+}
+
+// UploadContext is the context-aware variant of upload.
+func (this *Ftp) UploadContext(ctx context.Context, local string, remote string) (res []*FtpResponse, len int64, err error) {
+	stop := this.watchContext(ctx, this.ctrlConnNetConn())
+	defer stop()
+
+	res = []*FtpResponse{}
+	if !this.params.keepAlive {
+		defer func() {
+			var r *FtpResponse
+			if r, err = this.quit(); err != nil {
+				return
+			}
+			res = append(res, r)
+			return
+		}()
+	}
+
+	var itf interface{}
+	var r *FtpResponse
+
+	if this.params.passive {
+		if r, itf, err = this.openPassiveConn(); err != nil {
+			return
+		}
+	} else {
+		if r, itf, err = this.openActiveConn(); err != nil {
+			return
+		}
+	}
+	res = append(res, r)
+
+	if dc, ok := itf.(net.Conn); ok {
+		dataStop := this.watchContext(ctx, dc)
+		defer dataStop()
+	} else if l, ok := itf.(net.Listener); ok {
+		// Active mode: itf is still just the listener at this point, so
+		// closing it on cancellation at least unblocks the Accept() that
+		// readBytes/fileTransfer is about to perform.
+		dataStop := this.watchContext(ctx, l)
+		defer dataStop()
+	}
+
+	var cmd = fmt.Sprintf("STOR %s", remote)
+	if r, err = this.CommandContext(ctx, cmd, 150); err != nil {
+		return
+	}
+	res = append(res, r)
+
+	if r, len, err = this.fileTransfer(UPLOAD, local, itf); err != nil {
+		return
+	}
+	res = append(res, r)
+	return
+}
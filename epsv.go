@@ -0,0 +1,173 @@
+package sftps
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AddressFamily selects which IP family EPRT advertises and the active-mode
+// listener binds to; EPSV does not use it. AddressFamilyAuto uses the IPv4
+// address found by getLocalIP and does not follow the family of the control
+// connection.
+type AddressFamily int
+
+const (
+	AddressFamilyAuto AddressFamily = iota
+	AddressFamilyIPv4
+	AddressFamilyIPv6
+)
+
+// epsvReply matches "(<d1><d2><d3><port><d4>)". Go's RE2 regexp engine has
+// no backreferences, so the three delimiter occurrences are captured
+// separately and compared for equality in Go code instead.
+var epsvReply = regexp.MustCompile(`\((.)(.)(.)(\d+)(.)\)`)
+
+// pasvHost returns the host to dial for a PASV/EPSV data connection. Many
+// servers sit behind NAT and report an unroutable LAN address in their
+// PASV/EPSV reply, so the control connection's own remote address is
+// trusted by default instead of re-resolving params.host.
+func (this *Ftp) pasvHost() string {
+	if tcpAddr, ok := this.ctrlConnNetConn().RemoteAddr().(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	return this.params.host
+}
+
+// epsv implements RFC 2428 EPSV: issue EPSV and parse the
+// "229 Entering Extended Passive Mode (|||port|)" reply, where the
+// delimiter is whatever character follows the opening paren and the two
+// fields before port (net-prt, net-addr) are left empty.
+func (this *Ftp) epsv() (res *FtpResponse, dataConn net.Conn, err error) {
+	if res, err = this.Command("EPSV", 229); err != nil {
+		return
+	}
+
+	matches := epsvReply.FindStringSubmatch(res.msg)
+	if matches == nil || matches[1] != matches[2] || matches[2] != matches[3] || matches[3] != matches[5] {
+		err = errors.New("Could not parse the EPSV reply.")
+		return
+	}
+
+	var port int
+	if port, err = strconv.Atoi(matches[4]); err != nil {
+		return
+	}
+
+	dataConn, err = net.Dial("tcp", net.JoinHostPort(this.pasvHost(), strconv.Itoa(port)))
+	return
+}
+
+// eprt implements RFC 2428 EPRT: issue EPRT |<af>|<addr>|<port>| using the
+// listener's port and the local address of the configured address family,
+// the same address openActiveConn binds the listener to.
+func (this *Ftp) eprt(listener net.Listener) (res *FtpResponse, err error) {
+	tcpAddr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		err = errors.New("Invalid Listener, a *net.TCPAddr is required for EPRT.")
+		return
+	}
+
+	af := this.params.addressFamily
+	var localIP string
+	switch af {
+	case AddressFamilyIPv6:
+		if localIP, err = this.getLocalAddr(AddressFamilyIPv6); err != nil {
+			return
+		}
+	case AddressFamilyIPv4:
+		if localIP, err = this.getLocalAddr(AddressFamilyIPv4); err != nil {
+			return
+		}
+	default:
+		if localIP, err = this.getLocalIP(); err != nil {
+			return
+		}
+	}
+
+	family := 1
+	if net.ParseIP(localIP).To4() == nil {
+		family = 2
+	}
+
+	cmd := fmt.Sprintf("EPRT |%d|%s|%d|", family, localIP, tcpAddr.Port)
+	res, err = this.Command(cmd, 200)
+	return
+}
+
+// getLocalAddr is the address-family-aware counterpart of getLocalIP,
+// needed to advertise an IPv6 local address via EPRT.
+func (this *Ftp) getLocalAddr(af AddressFamily) (ip string, err error) {
+	var addrs []net.Addr
+	if addrs, err = net.InterfaceAddrs(); err != nil {
+		return
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() {
+			continue
+		}
+		if af == AddressFamilyIPv6 {
+			if ipnet.IP.To4() == nil {
+				ip = ipnet.IP.String()
+			}
+		} else {
+			if ipnet.IP.To4() != nil {
+				ip = ipnet.IP.To4().String()
+			}
+		}
+	}
+	if ip == "" {
+		err = errors.New("Could not get the Local Address.")
+	}
+	return
+}
+
+// openPassiveConn negotiates a passive-mode data connection, preferring
+// EPSV (works for both IPv4 and IPv6, and behind NAT) and falling back to
+// the IPv4-only PASV when the server rejects EPSV.
+func (this *Ftp) openPassiveConn() (res *FtpResponse, dataConn net.Conn, err error) {
+	if res, dataConn, err = this.epsv(); err == nil {
+		return
+	}
+	return this.pasv()
+}
+
+// openActiveConn negotiates an active-mode data connection, preferring
+// EPRT and falling back to the IPv4-only PORT on the same listener when
+// the server rejects EPRT.
+func (this *Ftp) openActiveConn() (res *FtpResponse, listener net.Listener, err error) {
+	var localIP string
+	switch this.params.addressFamily {
+	case AddressFamilyIPv4, AddressFamilyIPv6:
+		localIP, err = this.getLocalAddr(this.params.addressFamily)
+	default:
+		localIP, err = this.getLocalIP()
+	}
+	if err != nil {
+		return
+	}
+	if listener, err = net.Listen("tcp", net.JoinHostPort(localIP, strconv.Itoa(this.params.listenPort))); err != nil {
+		return
+	}
+
+	if res, err = this.eprt(listener); err == nil {
+		return
+	}
+
+	var p1, p2 int
+	if p1, p2, err = this.getSplitPorts(); err != nil {
+		listener.Close()
+		return
+	}
+	ip := strings.Replace(localIP, ".", ",", -1)
+	cmd := fmt.Sprintf("PORT %s,%d,%d", ip, p1, p2)
+	if res, err = this.Command(cmd, 200); err != nil {
+		listener.Close()
+		return
+	}
+	return
+}
@@ -17,11 +17,39 @@ import (
 )
 
 type Ftp struct {
-	rawConn  net.Conn
-	tlsConn  *tls.Conn
-	ctrlConn *textproto.Conn
-	params   *ftpParameters
-	State    int
+	rawConn      net.Conn
+	tlsConn      *tls.Conn
+	ctrlConn     *textproto.Conn
+	params       *ftpParameters
+	State        int
+	sessionCache tls.ClientSessionCache
+	feat         string
+}
+
+// TLSConfig carries the TLS parameters used to upgrade both the control
+// connection and the per-transfer data connection. Any zero-valued field
+// falls back to a sane default rather than a legacy/insecure one.
+type TLSConfig struct {
+	// MinVersion is the lowest TLS version the client will negotiate.
+	// Defaults to tls.VersionTLS12 when unset.
+	MinVersion uint16
+	// MaxVersion is the highest TLS version the client will negotiate.
+	// Defaults to the crypto/tls package default when unset.
+	MaxVersion uint16
+	// CipherSuites overrides the Go default cipher suite selection.
+	// Leave empty to use crypto/tls's own (modern) defaults.
+	CipherSuites []uint16
+	// ServerName is sent via SNI and used for certificate verification.
+	// Defaults to ftpParameters.host when unset.
+	ServerName string
+	// SystemCertPool, when true, falls back to the host's system cert
+	// pool to verify the server certificate if no rootCA is configured.
+	SystemCertPool bool
+	// ReuseSession enables TLS session resumption (RFC 4217) between the
+	// control connection and the data connection, as required by several
+	// FTPS servers (vsftpd, FileZilla) that reject a data channel which
+	// does not resume the control channel's TLS session.
+	ReuseSession bool
 }
 
 func newFtp(p *ftpParameters) (ftp *Ftp) {
@@ -95,43 +123,62 @@ func (this *Ftp) getTLSConfig() (conf *tls.Config, err error) {
 	var certPool *x509.CertPool
 	var rcaPem []byte
 
+	tc := this.params.tlsConfig
+	if tc == nil {
+		tc = &TLSConfig{}
+	}
+
 	conf = new(tls.Config)
-	conf.ClientAuth = tls.VerifyClientCertIfGiven
-	conf.CipherSuites = []uint16{
-		tls.TLS_RSA_WITH_AES_128_CBC_SHA,
-		tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-		tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
-		tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
-		tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
-		tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+
+	conf.ServerName = tc.ServerName
+	if conf.ServerName == "" {
+		conf.ServerName = this.params.host
+	}
+
+	conf.MinVersion = tc.MinVersion
+	if conf.MinVersion == 0 {
+		conf.MinVersion = tls.VersionTLS12
+	}
+	conf.MaxVersion = tc.MaxVersion
+
+	if len(tc.CipherSuites) > 0 {
+		conf.CipherSuites = tc.CipherSuites
 	}
 
 	if this.params.cert != "" && this.params.key != "" {
 		if certPair, err = tls.LoadX509KeyPair(this.params.cert, this.params.key); err != nil {
 			return
 		}
+		conf.Certificates = make([]tls.Certificate, 1)
+		conf.Certificates[0] = certPair
+	}
 
-		certPool = x509.NewCertPool()
-
-		if this.params.rootCA != "" {
-			if rcaPem, err = ioutil.ReadFile("./cert/rcaPem.pem"); err != nil {
-				return
-			}
+	if this.params.rootCA != "" {
+		if rcaPem, err = ioutil.ReadFile(this.params.rootCA); err != nil {
+			return
+		}
 
-			if this.params.alwaysTrust {
-				if !certPool.AppendCertsFromPEM(rcaPem) {
-					panic("Failed to parse the Root Certificate")
-				}
-			}
-			conf.RootCAs = certPool
+		certPool = x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(rcaPem) {
+			err = errors.New("Failed to parse the Root Certificate.")
+			return
+		}
+	} else if tc.SystemCertPool {
+		if certPool, err = x509.SystemCertPool(); err != nil {
+			return
 		}
+	}
+	if certPool != nil {
+		conf.RootCAs = certPool
+	}
 
-		conf.Certificates = make([]tls.Certificate, 1)
-		conf.Certificates[0] = certPair
-		conf.ClientCAs = certPool
+	if tc.ReuseSession {
+		if this.sessionCache == nil {
+			this.sessionCache = tls.NewLRUClientSessionCache(0)
+		}
+		conf.ClientSessionCache = this.sessionCache
 	}
+
 	conf.InsecureSkipVerify = this.params.alwaysTrust
 	return
 }
@@ -198,6 +245,7 @@ func (this *Ftp) options() (res []*FtpResponse, err error) {
 		return
 	}
 	res = append(res, r)
+	this.feat = r.msg
 
 	if r, err = this.Command("OPTS UTF8 ON", 200); err != nil {
 		return
@@ -314,10 +362,6 @@ func (this *Ftp) pasv() (res *FtpResponse, dataConn net.Conn, err error) {
 	if res, err = this.Command("PASV", 227); err != nil {
 		return
 	}
-	var ip []net.IP
-	if ip, err = net.LookupIP(this.params.host); err != nil {
-		return
-	}
 	reg := regexp.MustCompile("([0-9]+?),([0-9]+?),([0-9]+?),([0-9]+?),([0-9]+?),([0-9]+)")
 	matches := reg.FindAllStringSubmatch(res.msg, -1)
 	tmp := matches[0]
@@ -334,7 +378,7 @@ func (this *Ftp) pasv() (res *FtpResponse, dataConn net.Conn, err error) {
 	if port, err = this.h2i(fmt.Sprintf("%s%s", hex1, hex2)); err != nil {
 		return
 	}
-	param := fmt.Sprintf("%s:%d", ip[0], port)
+	param := fmt.Sprintf("%s:%d", this.pasvHost(), port)
 
 	dataConn, err = net.Dial("tcp", param)
 	return
@@ -435,11 +479,11 @@ func (this *Ftp) list(p string) (res []*FtpResponse, list string, err error) {
 	cmd := fmt.Sprintf("LIST -aL %s", p)
 
 	if this.params.passive {
-		if r, itf, err = this.pasv(); err != nil {
+		if r, itf, err = this.openPassiveConn(); err != nil {
 			return
 		}
 	} else {
-		if r, itf, err = this.port(); err != nil {
+		if r, itf, err = this.openActiveConn(); err != nil {
 			return
 		}
 	}
@@ -477,11 +521,11 @@ func (this *Ftp) download(local string, remote string) (res []*FtpResponse, len
 	var r *FtpResponse
 
 	if this.params.passive {
-		if r, itf, err = this.pasv(); err != nil {
+		if r, itf, err = this.openPassiveConn(); err != nil {
 			return
 		}
 	} else {
-		if r, itf, err = this.port(); err != nil {
+		if r, itf, err = this.openActiveConn(); err != nil {
 			return
 		}
 	}
@@ -517,11 +561,11 @@ func (this *Ftp) upload(local string, remote string) (res []*FtpResponse, len in
 	var r *FtpResponse
 
 	if this.params.passive {
-		if r, itf, err = this.pasv(); err != nil {
+		if r, itf, err = this.openPassiveConn(); err != nil {
 			return
 		}
 	} else {
-		if r, itf, err = this.port(); err != nil {
+		if r, itf, err = this.openActiveConn(); err != nil {
 			return
 		}
 	}
@@ -567,75 +611,33 @@ func (this *Ftp) rename(old, new string) (res []*FtpResponse, err error) {
 	return
 }
 
+// fileTransfer is kept for the file-path based download/upload callers and
+// is now just a thin os.Open/os.Create shim around the streaming transfer
+// primitive in stream.go, so file transfers and io.Reader/io.Writer
+// transfers share one code path for data-connection handling, TLS wrapping
+// and progress reporting.
 func (this *Ftp) fileTransfer(direction int, uri string, itf interface{}) (res *FtpResponse, len int64, err error) {
+	var f *os.File
+	var r io.Reader
+	var w io.Writer
 
-	var dataConn net.Conn
-
-	if this.params.passive {
-		if c, ok := itf.(net.Conn); ok {
-			dataConn = c
-			defer dataConn.Close()
-		} else {
-			err = errors.New("Invalid parameter were bound, Value of the argument 'itf' must be the Type 'net.Conn' when the Passive Mode specified by the Parameter.")
-			return
-		}
-	} else {
-		if listener, ok := itf.(net.Listener); ok {
-			defer listener.Close()
-			if dataConn, err = listener.Accept(); err != nil {
-				return
-			}
-		} else {
-			err = errors.New("Invalid parameter were bound, Value of the argument 'itf' must be the Type 'net.Listener' whern the Active Mode speciffied by the Parameter")
-			return
-		}
-	}
-
-	var r io.ReadCloser
-	var w io.WriteCloser
-	var rw io.ReadWriteCloser = dataConn
-
-	if this.params.secure {
-		var conf *tls.Config
-		if conf, err = this.getTLSConfig(); err != nil {
-			return
-		}
-		dataTLS := tls.Client(dataConn, conf)
-		defer dataTLS.Close()
-		rw = dataTLS
-	}
-
-	if direction == DOWNLOAD {
-		if w, err = os.Create(uri); err != nil {
+	switch direction {
+	case DOWNLOAD:
+		if f, err = os.Create(uri); err != nil {
 			return
 		}
-		r = rw
-	} else if direction == UPLOAD {
-
-		if r, err = os.Open(uri); err != nil {
+		w = f
+	case UPLOAD:
+		if f, err = os.Open(uri); err != nil {
 			return
 		}
-		w = rw
-	} else {
+		r = f
+	default:
 		err = errors.New("The Argument 'direction' must be the either 'DOWNLOAD' or 'UPLOAD'.")
 		return
 	}
+	defer f.Close()
 
-	if len, err = io.Copy(w, r); err != nil {
-		return
-	}
-	r.Close()
-	w.Close()
-
-	var code int
-	var msg string
-	if code, msg, err = this.ctrlConn.ReadResponse(226); err != nil {
-		return
-	}
-	res = &FtpResponse{
-		command: "",
-		code:    code,
-		msg:     msg,
-	}
+	res, len, err = this.transfer(direction, itf, r, w)
 	return
 }
@@ -0,0 +1,182 @@
+package sftps
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileEntry is a single parsed directory entry, whether it came from the
+// standardized RFC 3659 MLSD/MLST facts or from a best-effort parse of a
+// legacy LIST reply.
+type FileEntry struct {
+	Name     string
+	Size     int64
+	Modify   time.Time
+	Type     string // "file", "dir", "cdir" or "pdir"
+	Perm     string
+	UniqueID string
+}
+
+// SupportsMLSD reports whether the server advertised MLSD/MLST support in
+// its cached FEAT response. options() must have been called first.
+func (this *Ftp) SupportsMLSD() bool {
+	return strings.Contains(strings.ToUpper(this.feat), "MLSD") ||
+		strings.Contains(strings.ToUpper(this.feat), "MLST")
+}
+
+// Mlsd issues the RFC 3659 MLSD command and returns the parsed listing.
+func (this *Ftp) Mlsd(path string) (entries []FileEntry, err error) {
+	var itf interface{}
+	if itf, err = this.openDataChannel(); err != nil {
+		return
+	}
+
+	if _, err = this.Command(fmt.Sprintf("MLSD %s", path), 150); err != nil {
+		return
+	}
+
+	var rw io.ReadWriteCloser
+	if rw, err = this.openDataConn(itf); err != nil {
+		return
+	}
+
+	var raw []byte
+	if raw, err = ioutil.ReadAll(rw); err != nil {
+		rw.Close()
+		return
+	}
+	rw.Close()
+
+	if _, _, err = this.ctrlConn.ReadResponse(226); err != nil {
+		return
+	}
+
+	entries, err = parseMlsxLines(string(raw))
+	return
+}
+
+// Mlst issues the RFC 3659 MLST command for a single path and returns its
+// parsed facts line.
+//
+// net/textproto.ReadResponse already strips the "250-"/"250 " status-code
+// prefix off every line of the reply before returning it, so res.msg no
+// longer has a "250" marker to tell the header/trailer lines apart from
+// the facts line - the facts line is instead identified as the one
+// containing a "fact=value" assignment.
+func (this *Ftp) Mlst(path string) (entry FileEntry, err error) {
+	var res *FtpResponse
+	if res, err = this.Command(fmt.Sprintf("MLST %s", path), 250); err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(res.msg, "\n") {
+		line = strings.TrimRight(line, "\r")
+		candidate := strings.TrimLeft(line, " ")
+		if candidate == "" || !strings.Contains(candidate, "=") {
+			continue
+		}
+		entry, err = parseMlsxLine(candidate)
+		return
+	}
+
+	err = errors.New("MLST reply did not contain a facts line.")
+	return
+}
+
+func parseMlsxLines(raw string) (entries []FileEntry, err error) {
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+	for _, line := range strings.Split(raw, "\n") {
+		if line == "" {
+			continue
+		}
+		var entry FileEntry
+		if entry, err = parseMlsxLine(line); err != nil {
+			return
+		}
+		entries = append(entries, entry)
+	}
+	err = nil
+	return
+}
+
+// parseMlsxLine parses a single "fact=value;fact=value; name" line shared
+// by MLSD and MLST replies.
+func parseMlsxLine(line string) (entry FileEntry, err error) {
+	parts := strings.SplitN(line, " ", 2)
+	if len(parts) != 2 {
+		err = fmt.Errorf("Invalid MLSx line: %q", line)
+		return
+	}
+	entry.Name = parts[1]
+
+	for _, fact := range strings.Split(parts[0], ";") {
+		if fact == "" {
+			continue
+		}
+		kv := strings.SplitN(fact, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.ToLower(kv[0]) {
+		case "size":
+			entry.Size, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "modify":
+			entry.Modify, _ = time.Parse("20060102150405", kv[1])
+		case "type":
+			entry.Type = strings.ToLower(kv[1])
+		case "perm":
+			entry.Perm = kv[1]
+		case "unique":
+			entry.UniqueID = kv[1]
+		}
+	}
+	return
+}
+
+var unixListLineRe = regexp.MustCompile(`^([\-dlbcps])[\-rwxXsStT]{9}\+?\s+\d+\s+\S+\s+\S+\s+(\d+)\s+\S+\s+\d+\s+[\d:]+\s+(.+)$`)
+
+var dosListLineRe = regexp.MustCompile(`^\d{2}-\d{2}-\d{2,4}\s+\d{2}:\d{2}(?:AM|PM)\s+(<DIR>|\d+)\s+(.+)$`)
+
+// ParseListOutput is a fallback for servers that don't support MLSD: it
+// recognizes both Unix "ls -l" style LIST output and the DOS/IIS style
+// used by IIS and some embedded FTP servers.
+func ParseListOutput(raw string) (entries []FileEntry) {
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if m := unixListLineRe.FindStringSubmatch(line); m != nil {
+			entry := FileEntry{Name: m[3]}
+			if m[1] == "d" {
+				entry.Type = "dir"
+			} else {
+				entry.Type = "file"
+			}
+			entry.Size, _ = strconv.ParseInt(m[2], 10, 64)
+			entries = append(entries, entry)
+			continue
+		}
+
+		if m := dosListLineRe.FindStringSubmatch(line); m != nil {
+			entry := FileEntry{Name: m[2]}
+			if m[1] == "<DIR>" {
+				entry.Type = "dir"
+			} else {
+				entry.Type = "file"
+				entry.Size, _ = strconv.ParseInt(m[1], 10, 64)
+			}
+			entries = append(entries, entry)
+			continue
+		}
+	}
+	return
+}
@@ -0,0 +1,230 @@
+package sftps
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+)
+
+// idleFtp is an idle, authenticated connection sitting in the pool along
+// with the time it was returned, used for idle-timeout eviction.
+type idleFtp struct {
+	ftp   *Ftp
+	since time.Time
+}
+
+// Pool manages a bounded set of authenticated *Ftp control connections so
+// that callers can run concurrent operations without each one juggling its
+// own connect/auth/quit lifecycle, since a single Ftp multiplexes one
+// control connection and is not safe for concurrent use.
+type Pool struct {
+	params      *ftpParameters
+	maxOpen     int
+	maxIdle     int
+	idleTimeout time.Duration
+
+	// sessionCache is shared across every connection this pool dials, so
+	// a TLS session negotiated on one control connection can be resumed
+	// by the next one the pool opens, not just by that connection's own
+	// data channel.
+	sessionCache tls.ClientSessionCache
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	idle    []*idleFtp
+	numOpen int
+	closed  bool
+}
+
+// NewPool creates a Pool bounded to maxOpen concurrently open connections
+// and maxIdle connections kept warm between uses. idleTimeout <= 0 disables
+// idle eviction. Connections are authenticated lazily: NewPool itself opens
+// nothing, a connection is only dialed and logged in the first time Get
+// needs one.
+func NewPool(params *ftpParameters, maxOpen int, maxIdle int, idleTimeout time.Duration) *Pool {
+	p := &Pool{
+		params:      params,
+		maxOpen:     maxOpen,
+		maxIdle:     maxIdle,
+		idleTimeout: idleTimeout,
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	if params.secure && params.tlsConfig != nil && params.tlsConfig.ReuseSession {
+		p.sessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	return p
+}
+
+// Get returns an authenticated *Ftp from the pool, reusing an idle
+// connection when a healthy one is available, dialing a new one when the
+// pool has room, or blocking until one of those becomes true or ctx is
+// done.
+func (this *Pool) Get(ctx context.Context) (ftp *Ftp, err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		if this.closed {
+			err = errors.New("sftps: pool is closed")
+			return
+		}
+
+		this.evictIdleLocked()
+
+		if len(this.idle) > 0 {
+			ic := this.idle[len(this.idle)-1]
+			this.idle = this.idle[:len(this.idle)-1]
+
+			this.mu.Unlock()
+			_, healthErr := ic.ftp.Command("NOOP", 200)
+			this.mu.Lock()
+
+			if healthErr == nil {
+				ftp = ic.ftp
+				return
+			}
+			this.numOpen--
+			this.cond.Broadcast()
+			this.mu.Unlock()
+			ic.ftp.quit()
+			this.mu.Lock()
+			continue
+		}
+
+		if this.numOpen < this.maxOpen {
+			this.numOpen++
+			this.mu.Unlock()
+			ftp, err = this.dial(ctx)
+			this.mu.Lock()
+			if err != nil {
+				this.numOpen--
+				this.cond.Broadcast()
+			}
+			return
+		}
+
+		stop := context.AfterFunc(ctx, this.cond.Broadcast)
+		this.cond.Wait()
+		stop()
+	}
+}
+
+// Put returns ftp to the pool for reuse, or closes it if the pool is full
+// or closed.
+func (this *Pool) Put(ftp *Ftp) {
+	this.mu.Lock()
+	if !this.closed && len(this.idle) < this.maxIdle {
+		this.idle = append(this.idle, &idleFtp{ftp: ftp, since: time.Now()})
+		this.cond.Broadcast()
+		this.mu.Unlock()
+		return
+	}
+	this.numOpen--
+	this.cond.Broadcast()
+	this.mu.Unlock()
+
+	ftp.quit()
+}
+
+// drop discards ftp instead of returning it to the pool, for callers that
+// know the connection is no longer usable (e.g. after a command failed).
+func (this *Pool) drop(ftp *Ftp) {
+	this.mu.Lock()
+	this.numOpen--
+	this.cond.Broadcast()
+	this.mu.Unlock()
+
+	ftp.quit()
+}
+
+// Do borrows a connection, runs fn with it, and returns it to the pool -
+// or discards it, if fn returned an error, since that connection's state
+// is no longer trustworthy.
+func (this *Pool) Do(ctx context.Context, fn func(*Ftp) error) (err error) {
+	var ftp *Ftp
+	if ftp, err = this.Get(ctx); err != nil {
+		return
+	}
+
+	if err = fn(ftp); err != nil {
+		this.drop(ftp)
+		return
+	}
+
+	this.Put(ftp)
+	return
+}
+
+// Close closes every idle connection and marks the pool closed; any Get
+// in flight returns an error, and connections later returned via Put are
+// closed rather than kept.
+func (this *Pool) Close() {
+	this.mu.Lock()
+	this.closed = true
+	idle := this.idle
+	this.idle = nil
+	this.cond.Broadcast()
+	this.mu.Unlock()
+
+	for _, ic := range idle {
+		ic.ftp.quit()
+	}
+}
+
+func (this *Pool) evictIdleLocked() {
+	if this.idleTimeout <= 0 || len(this.idle) == 0 {
+		return
+	}
+
+	cutoff := time.Now().Add(-this.idleTimeout)
+	fresh := this.idle[:0]
+	var expired []*idleFtp
+	for _, ic := range this.idle {
+		if ic.since.Before(cutoff) {
+			expired = append(expired, ic)
+			continue
+		}
+		fresh = append(fresh, ic)
+	}
+	this.idle = fresh
+	this.numOpen -= len(expired)
+
+	if len(expired) > 0 {
+		this.mu.Unlock()
+		for _, ic := range expired {
+			ic.ftp.quit()
+		}
+		this.mu.Lock()
+	}
+}
+
+// dial opens, authenticates and primes a fresh connection for the pool.
+func (this *Pool) dial(ctx context.Context) (ftp *Ftp, err error) {
+	f := newFtp(this.params)
+	if this.sessionCache != nil {
+		f.sessionCache = this.sessionCache
+	}
+
+	if _, err = f.ConnectContext(ctx); err != nil {
+		return
+	}
+
+	if _, err = f.auth(); err != nil {
+		f.quit()
+		return
+	}
+
+	if _, err = f.options(); err != nil {
+		f.quit()
+		return
+	}
+
+	ftp = f
+	return
+}
@@ -0,0 +1,350 @@
+package sftps
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFTPServer is a minimal single-process FTP server, just enough to
+// exercise Pool: USER/PASS/SYST/FEAT/OPTS/TYPE for the auth+options
+// handshake, NOOP for health checks, and EPRT+STOR/RETR (dialing back to
+// the client's own listener, as in active mode) for transfers against an
+// in-memory file store shared across connections.
+type fakeFTPServer struct {
+	listener net.Listener
+	files    sync.Map // name -> []byte
+
+	// noopGate, when non-nil, is read from before a connection's NOOP
+	// handler replies, letting a test hold a NOOP response hostage to
+	// deterministically land a concurrent Get() in the middle of a
+	// health check instead of racing real network timing.
+	noopGate chan struct{}
+}
+
+func startFakeFTPServer(t *testing.T) *fakeFTPServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start fake FTP server: %v", err)
+	}
+
+	s := &fakeFTPServer{listener: ln}
+	go s.serve()
+	return s
+}
+
+func (this *fakeFTPServer) addr() string {
+	return this.listener.Addr().String()
+}
+
+func (this *fakeFTPServer) close() {
+	this.listener.Close()
+}
+
+func (this *fakeFTPServer) serve() {
+	for {
+		conn, err := this.listener.Accept()
+		if err != nil {
+			return
+		}
+		go this.handle(conn)
+	}
+}
+
+func (this *fakeFTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	writeLine(conn, "220 fake ftp ready")
+	scanner := bufio.NewScanner(conn)
+
+	// dataConns carries the data connection dialed back for the most
+	// recent EPRT, across to the STOR/RETR that follows it.
+	dataConns := make(chan net.Conn, 1)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case hasPrefix(line, "USER"):
+			writeLine(conn, "331 need password")
+		case hasPrefix(line, "PASS"):
+			writeLine(conn, "230 logged in")
+		case hasPrefix(line, "SYST"):
+			writeLine(conn, "215 UNIX Type: L8")
+		case hasPrefix(line, "FEAT"):
+			writeLine(conn, "211 no extra features")
+		case hasPrefix(line, "OPTS"):
+			writeLine(conn, "200 ok")
+		case hasPrefix(line, "TYPE"):
+			writeLine(conn, "200 ok")
+		case hasPrefix(line, "NOOP"):
+			if gate := this.noopGate; gate != nil {
+				<-gate
+				writeLine(conn, "500 forced NOOP failure")
+			} else {
+				writeLine(conn, "200 ok")
+			}
+		case hasPrefix(line, "EPRT"):
+			this.handleEprt(conn, line[len("EPRT "):], dataConns)
+		case hasPrefix(line, "STOR"):
+			this.handleStor(conn, line[len("STOR "):], dataConns)
+		case hasPrefix(line, "RETR"):
+			this.handleRetr(conn, line[len("RETR "):], dataConns)
+		case hasPrefix(line, "QUIT"):
+			writeLine(conn, "221 bye")
+			return
+		default:
+			writeLine(conn, "502 not implemented")
+		}
+	}
+}
+
+// handleEprt parses "|<family>|<addr>|<port>|" and dials back to it, as a
+// real active-mode server would.
+func (this *fakeFTPServer) handleEprt(conn net.Conn, args string, dataConns chan net.Conn) {
+	fields := strings.Split(strings.TrimSpace(args), "|")
+	if len(fields) < 4 {
+		writeLine(conn, "501 bad EPRT arguments")
+		return
+	}
+	addr, port := fields[2], fields[3]
+
+	writeLine(conn, "200 EPRT command successful")
+
+	go func() {
+		dc, err := net.Dial("tcp", net.JoinHostPort(addr, port))
+		if err != nil {
+			return
+		}
+		select {
+		case dataConns <- dc:
+		case <-time.After(5 * time.Second):
+			dc.Close()
+		}
+	}()
+}
+
+func (this *fakeFTPServer) handleStor(conn net.Conn, name string, dataConns chan net.Conn) {
+	writeLine(conn, "150 opening data connection")
+
+	dc := <-dataConns
+	if dc == nil {
+		writeLine(conn, "426 connection aborted")
+		return
+	}
+	defer dc.Close()
+
+	buf := make([]byte, 0, 4096)
+	tmp := make([]byte, 4096)
+	for {
+		n, err := dc.Read(tmp)
+		if n > 0 {
+			buf = append(buf, tmp[:n]...)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	this.files.Store(name, buf)
+	writeLine(conn, "226 transfer complete")
+}
+
+func (this *fakeFTPServer) handleRetr(conn net.Conn, name string, dataConns chan net.Conn) {
+	writeLine(conn, "150 opening data connection")
+
+	dc := <-dataConns
+	if dc == nil {
+		writeLine(conn, "426 connection aborted")
+		return
+	}
+
+	if data, ok := this.files.Load(name); ok {
+		dc.Write(data.([]byte))
+	}
+	dc.Close()
+
+	writeLine(conn, "226 transfer complete")
+}
+
+func writeLine(conn net.Conn, s string) {
+	conn.Write([]byte(s + "\r\n"))
+}
+
+func hasPrefix(line, prefix string) bool {
+	return len(line) >= len(prefix) && line[:len(prefix)] == prefix
+}
+
+func testParams(addr string) *ftpParameters {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		panic(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		panic(err)
+	}
+
+	return &ftpParameters{
+		host:      host,
+		port:      port,
+		user:      "test",
+		pass:      "test",
+		passive:   false,
+		keepAlive: true,
+	}
+}
+
+func TestPoolConcurrentUploadsAndDownloads(t *testing.T) {
+	server := startFakeFTPServer(t)
+	defer server.close()
+
+	pool := NewPool(testParams(server.addr()), 4, 4, time.Minute)
+	defer pool.Close()
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errCh := make(chan error, 16)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("file-%d.txt", i)
+
+			err := pool.Do(ctx, func(ftp *Ftp) error {
+				_, err := ftp.UploadFrom(name, strings.NewReader("hello from pool"))
+				return err
+			})
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			var buf bytes.Buffer
+			err = pool.Do(ctx, func(ftp *Ftp) error {
+				_, err := ftp.DownloadTo(name, &buf)
+				return err
+			})
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if buf.String() != "hello from pool" {
+				errCh <- fmt.Errorf("unexpected content for %s: %q", name, buf.String())
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		t.Errorf("pool operation failed: %v", err)
+	}
+}
+
+func TestPoolRecoversFromForcedDisconnect(t *testing.T) {
+	server := startFakeFTPServer(t)
+	defer server.close()
+
+	pool := NewPool(testParams(server.addr()), 2, 2, time.Minute)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	ftp, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	ftp.ctrlConnNetConn().Close() // simulate the server vanishing mid-session
+	pool.drop(ftp)
+
+	if err := pool.Do(ctx, func(ftp *Ftp) error {
+		_, err := ftp.Command("NOOP", 200)
+		return err
+	}); err != nil {
+		t.Fatalf("pool did not recover from a forced disconnect: %v", err)
+	}
+}
+
+// TestPoolGetRecoversFromDeadIdleConnection exercises the idle-health-check
+// failure branch inside Get itself, with maxOpen saturated and a second
+// caller genuinely parked in cond.Wait() for a slot - not drop's path, and
+// not a Get that happens to find room on its own first try. The fake
+// server's noopGate holds the health-check reply hostage so the test can
+// land the waiting Get() in cond.Wait() before the first Get() discovers
+// the idle connection is dead.
+//
+// The first Get() runs under a context that gets cancelled the moment its
+// health check is allowed to fail, so it bails out via ctx.Err() on its
+// next loop iteration instead of also competing for the slot its own
+// numOpen-- just freed. That leaves the waiting Get() as the only
+// remaining claimant of that slot, so its recovery can only be explained
+// by the numOpen-- branch's own broadcast - not by racing the first Get()
+// for the same freed capacity, and not by some later, unrelated Put.
+func TestPoolGetRecoversFromDeadIdleConnection(t *testing.T) {
+	server := startFakeFTPServer(t)
+	defer server.close()
+	server.noopGate = make(chan struct{})
+
+	pool := NewPool(testParams(server.addr()), 1, 1, time.Minute)
+	defer pool.Close()
+
+	ftp, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	pool.Put(ftp) // idle=[ftp], numOpen=1 (== maxOpen)
+
+	discovererCtx, cancelDiscoverer := context.WithCancel(context.Background())
+	defer cancelDiscoverer()
+
+	// Pops the idle connection and blocks inside its NOOP health check,
+	// held open by noopGate, with the pool's lock released.
+	discovererDone := make(chan error, 1)
+	go func() {
+		_, err := pool.Get(discovererCtx)
+		discovererDone <- err
+	}()
+
+	// Give the goroutine above time to pop the idle connection and reach
+	// the gated NOOP, so that this second Get() observes idle empty and
+	// numOpen == maxOpen, and genuinely parks in cond.Wait().
+	time.Sleep(100 * time.Millisecond)
+
+	waiterDone := make(chan error, 1)
+	go func() {
+		ftp, err := pool.Get(context.Background())
+		if err == nil {
+			pool.Put(ftp)
+		}
+		waiterDone <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	cancelDiscoverer()
+	close(server.noopGate) // let the forced "500" NOOP failure through
+
+	if err := <-discovererDone; err != context.Canceled {
+		t.Fatalf("discoverer Get returned %v, want context.Canceled", err)
+	}
+
+	select {
+	case err := <-waiterDone:
+		if err != nil {
+			t.Fatalf("waiting Get failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get blocked forever: numOpen-- on a dead idle connection did not wake a waiter parked at maxOpen")
+	}
+}
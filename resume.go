@@ -0,0 +1,173 @@
+package sftps
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SupportsREST reports whether the server advertised REST STREAM support
+// in its cached FEAT response. options() must have been called first.
+func (this *Ftp) SupportsREST() bool {
+	return strings.Contains(strings.ToUpper(this.feat), "REST STREAM")
+}
+
+// Size issues the RFC 3659 SIZE command and returns the remote file's
+// length in bytes.
+func (this *Ftp) Size(remote string) (size int64, err error) {
+	var res *FtpResponse
+	if res, err = this.Command(fmt.Sprintf("SIZE %s", remote), 213); err != nil {
+		return
+	}
+	size, err = strconv.ParseInt(strings.TrimSpace(res.msg), 10, 64)
+	return
+}
+
+// ModTime issues the RFC 3659 MDTM command and returns the remote file's
+// last modification time.
+func (this *Ftp) ModTime(remote string) (t time.Time, err error) {
+	var res *FtpResponse
+	if res, err = this.Command(fmt.Sprintf("MDTM %s", remote), 213); err != nil {
+		return
+	}
+	t, err = time.Parse("20060102150405", strings.TrimSpace(res.msg))
+	return
+}
+
+// ResumeDownload continues an interrupted download of remote into local,
+// picking up from the length of whatever local already holds. If remote
+// was modified since local's last write, the partial file is considered
+// stale and the download restarts from zero instead.
+func (this *Ftp) ResumeDownload(local, remote string) (n int64, err error) {
+	if !this.SupportsREST() {
+		err = errors.New("Server does not advertise REST STREAM support.")
+		return
+	}
+
+	var offset int64
+	var localModTime time.Time
+	if fi, statErr := os.Stat(local); statErr == nil {
+		offset = fi.Size()
+		localModTime = fi.ModTime()
+	} else if !os.IsNotExist(statErr) {
+		err = statErr
+		return
+	}
+
+	var remoteModTime time.Time
+	if remoteModTime, err = this.ModTime(remote); err != nil {
+		return
+	}
+	if offset > 0 && !localModTime.IsZero() && remoteModTime.After(localModTime) {
+		offset = 0
+	}
+
+	var remoteSize int64
+	if remoteSize, err = this.Size(remote); err != nil {
+		return
+	}
+	if offset >= remoteSize {
+		return
+	}
+
+	// offset == 0 covers both a missing local file and the stale-mtime
+	// reset above, and either way the file must be truncated: without
+	// O_TRUNC, restarting from zero over an existing partial file would
+	// leave its old trailing bytes past the new transfer length.
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	var f *os.File
+	if f, err = os.OpenFile(local, flags, 0644); err != nil {
+		return
+	}
+	defer f.Close()
+	if offset > 0 {
+		if _, err = f.Seek(offset, io.SeekStart); err != nil {
+			return
+		}
+	}
+
+	if !this.params.keepAlive {
+		defer this.quit()
+	}
+
+	var itf interface{}
+	if itf, err = this.openDataChannel(); err != nil {
+		return
+	}
+
+	if offset > 0 {
+		if _, err = this.Command(fmt.Sprintf("REST %d", offset), 350); err != nil {
+			return
+		}
+	}
+
+	if _, err = this.Command(fmt.Sprintf("RETR %s", remote), 150); err != nil {
+		return
+	}
+
+	_, n, err = this.transfer(DOWNLOAD, itf, nil, f)
+	return
+}
+
+// ResumeUpload continues an interrupted upload of local to remote, picking
+// up from however much of remote the server already has.
+func (this *Ftp) ResumeUpload(local, remote string) (n int64, err error) {
+	if !this.SupportsREST() {
+		err = errors.New("Server does not advertise REST STREAM support.")
+		return
+	}
+
+	var remoteSize int64
+	if remoteSize, err = this.Size(remote); err != nil {
+		remoteSize = 0
+		err = nil
+	}
+
+	var f *os.File
+	if f, err = os.Open(local); err != nil {
+		return
+	}
+	defer f.Close()
+
+	var fi os.FileInfo
+	if fi, err = f.Stat(); err != nil {
+		return
+	}
+	if remoteSize >= fi.Size() {
+		return
+	}
+	if remoteSize > 0 {
+		if _, err = f.Seek(remoteSize, io.SeekStart); err != nil {
+			return
+		}
+	}
+
+	if !this.params.keepAlive {
+		defer this.quit()
+	}
+
+	var itf interface{}
+	if itf, err = this.openDataChannel(); err != nil {
+		return
+	}
+
+	if remoteSize > 0 {
+		if _, err = this.Command(fmt.Sprintf("REST %d", remoteSize), 350); err != nil {
+			return
+		}
+	}
+
+	if _, err = this.Command(fmt.Sprintf("STOR %s", remote), 150); err != nil {
+		return
+	}
+
+	_, n, err = this.transfer(UPLOAD, itf, f, nil)
+	return
+}
@@ -0,0 +1,231 @@
+package sftps
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// ProgressFunc is invoked as data moves across a data connection, reporting
+// the number of bytes transferred so far and the total size. transfer does
+// not look up the size of a transfer, for files or streams alike, so total
+// is always reported as 0.
+type ProgressFunc func(transferred, total int64)
+
+// progressReader wraps an io.Reader and reports progress as it is read,
+// mirroring the rate-limited/counted reader pattern used to instrument
+// proxied transfers elsewhere.
+type progressReader struct {
+	r           io.Reader
+	total       int64
+	transferred int64
+	onProgress  ProgressFunc
+}
+
+func (this *progressReader) Read(buf []byte) (n int, err error) {
+	n, err = this.r.Read(buf)
+	if n > 0 {
+		this.transferred += int64(n)
+		this.onProgress(this.transferred, this.total)
+	}
+	return
+}
+
+// progressWriter wraps an io.Writer and reports progress as it is written.
+type progressWriter struct {
+	w           io.Writer
+	total       int64
+	transferred int64
+	onProgress  ProgressFunc
+}
+
+func (this *progressWriter) Write(buf []byte) (n int, err error) {
+	n, err = this.w.Write(buf)
+	if n > 0 {
+		this.transferred += int64(n)
+		this.onProgress(this.transferred, this.total)
+	}
+	return
+}
+
+// openDataConn accepts or dereferences the net.Conn/net.Listener handed
+// back by openPassiveConn/openActiveConn and wraps it in TLS when PROT P is in effect, yielding
+// a single io.ReadWriteCloser regardless of passive/active mode.
+func (this *Ftp) openDataConn(itf interface{}) (rw io.ReadWriteCloser, err error) {
+	var dataConn net.Conn
+
+	if this.params.passive {
+		if dc, ok := itf.(net.Conn); ok {
+			dataConn = dc
+		} else {
+			err = errors.New("Invalid parameter were bound, net.Conn is not found.")
+			return
+		}
+	} else {
+		if listener, ok := itf.(net.Listener); ok {
+			defer listener.Close()
+			if dataConn, err = listener.Accept(); err != nil {
+				return
+			}
+		} else {
+			err = errors.New("Invalid parameter were bound, net.Listener is not found.")
+			return
+		}
+	}
+
+	if this.params.secure {
+		var conf *tls.Config
+		if conf, err = this.getTLSConfig(); err != nil {
+			return
+		}
+		rw = tls.Client(dataConn, conf)
+		return
+	}
+
+	rw = dataConn
+	return
+}
+
+// transfer copies between the data connection behind itf and r/w
+// (exactly one of which is set, per direction), reporting progress via
+// params.progress when configured, and reads the trailing 226 response
+// off the control connection once the copy completes.
+func (this *Ftp) transfer(direction int, itf interface{}, r io.Reader, w io.Writer) (res *FtpResponse, n int64, err error) {
+	var rw io.ReadWriteCloser
+	if rw, err = this.openDataConn(itf); err != nil {
+		return
+	}
+	defer rw.Close()
+
+	switch direction {
+	case DOWNLOAD:
+		r = rw
+		if this.params.progress != nil {
+			w = &progressWriter{w: w, onProgress: this.params.progress}
+		}
+	case UPLOAD:
+		w = rw
+		if this.params.progress != nil {
+			r = &progressReader{r: r, onProgress: this.params.progress}
+		}
+	default:
+		err = errors.New("The Argument 'direction' must be the either 'DOWNLOAD' or 'UPLOAD'.")
+		return
+	}
+
+	if n, err = io.Copy(w, r); err != nil {
+		return
+	}
+	rw.Close()
+
+	var code int
+	var msg string
+	if code, msg, err = this.ctrlConn.ReadResponse(226); err != nil {
+		return
+	}
+	res = &FtpResponse{
+		command: "",
+		code:    code,
+		msg:     msg,
+	}
+	return
+}
+
+// openDataChannel negotiates a passive or active data connection depending
+// on params.passive and returns the raw net.Conn/net.Listener handle for
+// the caller to pass straight through to transfer/openDataConn.
+func (this *Ftp) openDataChannel() (itf interface{}, err error) {
+	if this.params.passive {
+		_, itf, err = this.openPassiveConn()
+		return
+	}
+	_, itf, err = this.openActiveConn()
+	return
+}
+
+// UploadFrom streams r to remote via STOR, without requiring the source to
+// be a local file. It moves the data through transfer, the same streaming
+// primitive that upload reaches via fileTransfer.
+func (this *Ftp) UploadFrom(remote string, r io.Reader) (n int64, err error) {
+	if !this.params.keepAlive {
+		defer this.quit()
+	}
+
+	var itf interface{}
+	if itf, err = this.openDataChannel(); err != nil {
+		return
+	}
+
+	if _, err = this.Command(fmt.Sprintf("STOR %s", remote), 150); err != nil {
+		return
+	}
+
+	_, n, err = this.transfer(UPLOAD, itf, r, nil)
+	return
+}
+
+// DownloadTo streams remote into w via RETR, without requiring the
+// destination to be a local file. It moves the data through transfer, the
+// same streaming primitive that download reaches via fileTransfer.
+func (this *Ftp) DownloadTo(remote string, w io.Writer) (n int64, err error) {
+	if !this.params.keepAlive {
+		defer this.quit()
+	}
+
+	var itf interface{}
+	if itf, err = this.openDataChannel(); err != nil {
+		return
+	}
+
+	if _, err = this.Command(fmt.Sprintf("RETR %s", remote), 150); err != nil {
+		return
+	}
+
+	_, n, err = this.transfer(DOWNLOAD, itf, nil, w)
+	return
+}
+
+// listStream is the io.ReadCloser returned by Ftp.ListStream. Closing it
+// both closes the underlying data connection and drains the 226 response
+// off the control connection, so callers must Close it before issuing any
+// further command on this Ftp.
+type listStream struct {
+	rw  io.ReadWriteCloser
+	ftp *Ftp
+}
+
+func (this *listStream) Read(buf []byte) (int, error) {
+	return this.rw.Read(buf)
+}
+
+func (this *listStream) Close() (err error) {
+	err = this.rw.Close()
+	if _, _, e := this.ftp.ctrlConn.ReadResponse(226); err == nil {
+		err = e
+	}
+	return
+}
+
+// ListStream issues LIST -aL path and returns the raw data connection as
+// an io.ReadCloser, so callers can stream a directory listing instead of
+// buffering it whole.
+func (this *Ftp) ListStream(path string) (rc io.ReadCloser, err error) {
+	var itf interface{}
+	if itf, err = this.openDataChannel(); err != nil {
+		return
+	}
+
+	if _, err = this.Command(fmt.Sprintf("LIST -aL %s", path), 150); err != nil {
+		return
+	}
+
+	var rw io.ReadWriteCloser
+	if rw, err = this.openDataConn(itf); err != nil {
+		return
+	}
+
+	rc = &listStream{rw: rw, ftp: this}
+	return
+}